@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+// validChecksumTypes are the checksum_type config values this post-processor
+// understands, matching the checksum_type values Vagrant itself accepts in
+// a box manifest.
+var validChecksumTypes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+func newChecksumHash(checksumType string) (hash.Hash, error) {
+	newHash, ok := validChecksumTypes[checksumType]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum_type %q", checksumType)
+	}
+	return newHash(), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// historySuffix is appended to the manifest key to derive the path of its
+// history sidecar, e.g. "boxes/my-box.json" -> "boxes/my-box.json.history.json".
+const historySuffix = ".history.json"
+
+// ManifestHistoryEntry records one write of the manifest object, so a
+// botched publish can be identified and rolled back via restoreManifestVersion.
+type ManifestHistoryEntry struct {
+	VersionId string    `json:"version_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Box       string    `json:"box_version,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+}
+
+type ManifestHistory struct {
+	Entries []*ManifestHistoryEntry `json:"entries"`
+}
+
+func historyKey(manifestPath string) string {
+	return manifestPath + historySuffix
+}
+
+// getManifestHistory fetches the history sidecar for the manifest, returning
+// an empty history if it doesn't exist yet.
+func (p *PostProcessor) getManifestHistory() (*ManifestHistory, error) {
+	result, err := p.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(p.config.Bucket),
+		Key:    aws.String(historyKey(p.config.ManifestPath)),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			if awsErr.Code() == "NoSuchKey" {
+				return &ManifestHistory{}, nil
+			}
+		}
+		return nil, err
+	}
+
+	defer result.Body.Close()
+
+	history := &ManifestHistory{}
+	if err := json.NewDecoder(result.Body).Decode(history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// recordManifestVersion appends an entry to the history sidecar for a
+// manifest write identified by versionId, and uploads it back to S3.
+func (p *PostProcessor) recordManifestVersion(versionId, box, provider string) error {
+	if versionId == "" {
+		// versioning isn't enabled on the bucket; nothing to record.
+		return nil
+	}
+
+	history, err := p.getManifestHistory()
+	if err != nil {
+		return err
+	}
+
+	history.Entries = append(history.Entries, &ManifestHistoryEntry{
+		VersionId: versionId,
+		Timestamp: time.Now().UTC(),
+		Box:       box,
+		Provider:  provider,
+	})
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(history); err != nil {
+		return err
+	}
+
+	_, err = p.s3.PutObject(&s3.PutObjectInput{
+		Body:        strings.NewReader(buf.String()),
+		Bucket:      aws.String(p.config.Bucket),
+		Key:         aws.String(historyKey(p.config.ManifestPath)),
+		ContentType: aws.String("application/json"),
+		ACL:         aws.String(p.config.ACL),
+	})
+	return err
+}
+
+// listManifestVersions lists the versions of the manifest object that S3 has
+// retained, newest first, for use by the "rollback" subcommand. It pages
+// through ListObjectVersions until IsTruncated is false, since the prefix
+// can match more keys than fit on one page (S3's default max is 1000,
+// shared across every key matching the prefix, not just the manifest).
+func (p *PostProcessor) listManifestVersions() ([]*s3.ObjectVersion, error) {
+	var versions []*s3.ObjectVersion
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(p.config.Bucket),
+		Prefix: aws.String(p.config.ManifestPath),
+	}
+
+	for {
+		result, err := p.s3.ListObjectVersions(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range result.Versions {
+			if aws.StringValue(v.Key) == p.config.ManifestPath {
+				versions = append(versions, v)
+			}
+		}
+
+		if !aws.BoolValue(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.VersionIdMarker = result.NextVersionIdMarker
+	}
+
+	return versions, nil
+}
+
+// restoreManifestVersion copies a prior VersionId of the manifest object
+// back to the current key, so a botched publish can be undone without
+// hand-editing JSON.
+func (p *PostProcessor) restoreManifestVersion(versionId string) error {
+	source := fmt.Sprintf("%s/%s?versionId=%s", url.QueryEscape(p.config.Bucket), copySourceEscapeKey(p.config.ManifestPath), url.QueryEscape(versionId))
+
+	_, err := p.s3.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(p.config.Bucket),
+		Key:        aws.String(p.config.ManifestPath),
+		CopySource: aws.String(source),
+		ACL:        aws.String(p.config.ACL),
+	})
+	return err
+}
+
+// copySourceEscapeKey URL-encodes key for use in a CopyObjectInput.CopySource
+// value, as required by the S3 API, without encoding the "/" path
+// separators (which url.QueryEscape would otherwise turn into %2F).
+func copySourceEscapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.QueryEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// checkBucketVersioning returns an error (which fails Configure) when
+// manifest_versioning is requested but the bucket's versioning is Suspended
+// (or never enabled), since in that state S3 won't retain prior manifest
+// versions to roll back to.
+func (p *PostProcessor) checkBucketVersioning() error {
+	result, err := p.s3.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(p.config.Bucket),
+	})
+	if err != nil {
+		return err
+	}
+
+	status := aws.StringValue(result.Status)
+	if status != s3.BucketVersioningStatusEnabled {
+		return fmt.Errorf("manifest_versioning is enabled but bucket %q has versioning %q; enable bucket versioning so manifest history/rollback works", p.config.Bucket, status)
+	}
+	return nil
+}
@@ -7,6 +7,14 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		if err := runRollback(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	pps := plugin.NewSet()
 	pps.RegisterPostProcessor(plugin.DEFAULT_NAME, new(PostProcessor))
 	err := pps.Run()
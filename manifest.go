@@ -2,15 +2,25 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/blang/semver"
 )
 
 const NoVersion = "0.0.0"
 
+// Vagrant Cloud box statuses. A version or provider marked "revoked" is
+// still present in the manifest (so existing installs keep working) but
+// should no longer be offered to new installs.
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
 type Manifest struct {
-	Name     string     `json:"name"`
-	Versions []*Version `json:"versions"`
+	Name      string     `json:"name"`
+	Versions  []*Version `json:"versions"`
+	Signature string     `json:"signature,omitempty"`
 }
 
 func (m *Manifest) add(version string, provider *Provider) error {
@@ -27,18 +37,29 @@ func (m *Manifest) add(version string, provider *Provider) error {
 	}
 	m.Versions = append(m.Versions, &Version{
 		Version:   version,
+		Status:    StatusActive,
+		CreatedAt: time.Now().UTC(),
 		Providers: []*Provider{provider},
 	})
 	return nil
 }
 
+// getLatestVersion returns the highest active, non-prerelease version in
+// the manifest. Prereleases (e.g. "1.2.3-rc1") are ignored, since semver
+// defines them as lower precedence than their final release and Vagrant
+// users doing a plain `vagrant box update` should never be bumped onto one.
 func (m *Manifest) getLatestVersion() string {
 	latestVersion, _ := semver.Make(NoVersion)
 
 	for _, version := range m.Versions {
-		if currentVersion, err := semver.Make(version.Version); err != nil {
+		currentVersion, err := semver.Make(version.Version)
+		if err != nil {
+			continue
+		}
+		if len(currentVersion.Pre) > 0 {
 			continue
-		} else if latestVersion.LT(currentVersion) {
+		}
+		if latestVersion.LT(currentVersion) {
 			latestVersion = currentVersion
 		}
 	}
@@ -46,22 +67,107 @@ func (m *Manifest) getLatestVersion() string {
 	return latestVersion.String()
 }
 
-func (m *Manifest) getNextVersion() string {
+// getNextVersion returns the next minor version after getLatestVersion. If
+// prerelease is non-empty, the returned version is a prerelease of that
+// next version (e.g. "1.3.0-rc1"), so publishing it never moves the
+// "latest" pointer used by plain `vagrant box update` commands.
+func (m *Manifest) getNextVersion(prerelease string) string {
 	latestVersion, _ := semver.Make(m.getLatestVersion())
 	latestVersion.Minor++
 	latestVersion.Patch = 0
 
+	if prerelease != "" {
+		pre, err := semver.NewPRVersion(prerelease)
+		if err == nil {
+			latestVersion.Pre = []semver.PRVersion{pre}
+		}
+	}
+
 	return latestVersion.String()
 }
 
+// prune removes versions and providers older than the retention window
+// configured by keepVersions/keepProviders, returning the ones that were
+// dropped so the caller can remove the corresponding objects from S3.
+//
+// Versions are ordered oldest-first by semver precedence before pruning,
+// so keepVersions always keeps the most recent N versions regardless of
+// the order they were added to the manifest.
+func (m *Manifest) prune(keepVersions, keepProviders int) (removedVersions []*Version, removedProviders []*Provider) {
+	if keepVersions <= 0 && keepProviders <= 0 {
+		return nil, nil
+	}
+
+	if keepProviders > 0 {
+		for _, version := range m.Versions {
+			if len(version.Providers) <= keepProviders {
+				continue
+			}
+			cut := len(version.Providers) - keepProviders
+			removedProviders = append(removedProviders, version.Providers[:cut]...)
+			version.Providers = version.Providers[cut:]
+		}
+	}
+
+	if keepVersions > 0 && len(m.Versions) > keepVersions {
+		sorted := make([]*Version, len(m.Versions))
+		copy(sorted, m.Versions)
+		sortVersions(sorted)
+
+		cut := len(sorted) - keepVersions
+		removedVersions = append(removedVersions, sorted[:cut]...)
+
+		kept := make(map[*Version]bool, keepVersions)
+		for _, v := range sorted[cut:] {
+			kept[v] = true
+		}
+
+		remaining := m.Versions[:0]
+		for _, v := range m.Versions {
+			if kept[v] {
+				remaining = append(remaining, v)
+			}
+		}
+		m.Versions = remaining
+	}
+
+	return removedVersions, removedProviders
+}
+
+// sortVersions orders versions oldest-first by semver precedence, treating
+// unparseable versions as oldest.
+func sortVersions(versions []*Version) {
+	for i := 1; i < len(versions); i++ {
+		for j := i; j > 0; j-- {
+			a, errA := semver.Make(versions[j-1].Version)
+			b, errB := semver.Make(versions[j].Version)
+			if errA != nil {
+				break
+			}
+			if errB == nil && a.LTE(b) {
+				break
+			}
+			versions[j-1], versions[j] = versions[j], versions[j-1]
+		}
+	}
+}
+
 type Version struct {
-	Version   string      `json:"version"`
-	Providers []*Provider `json:"providers"`
+	Version             string      `json:"version"`
+	Status              string      `json:"status,omitempty"`
+	DescriptionMarkdown string      `json:"description_markdown,omitempty"`
+	CreatedAt           time.Time   `json:"created_at,omitempty"`
+	Providers           []*Provider `json:"providers"`
 }
 
 type Provider struct {
-	Name         string `json:"name"`
-	Url          string `json:"url"`
+	Name string `json:"name"`
+	Url  string `json:"url"`
+	// Key is the box's store object key, recorded separately from Url since
+	// Url may be a presigned, time-limited link (signed_expiry) that pruning
+	// can't reverse back into a key.
+	Key          string `json:"key,omitempty"`
 	ChecksumType string `json:"checksum_type"`
 	Checksum     string `json:"checksum"`
+	SignatureUrl string `json:"signature_url,omitempty"`
 }
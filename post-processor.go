@@ -5,22 +5,25 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/packer/common"
 	"github.com/hashicorp/packer/helper/config"
@@ -29,32 +32,66 @@ import (
 )
 
 type Config struct {
-	Region              string        `mapstructure:"region"`
-	Bucket              string        `mapstructure:"bucket"`
-	CloudFront          string        `mapstructure:"cloudfront"`
-	ManifestPath        string        `mapstructure:"manifest"`
-	BoxName             string        `mapstructure:"box_name"`
-	BoxDir              string        `mapstructure:"box_dir"`
-	Version             string        `mapstructure:"version"`
-	ACL                 string        `mapstructure:"acl"`
-	CredentialFile      string        `mapstructure:"credentials"`
-	CredentialProfile   string        `mapstructure:"profile"`
-	AccessKey           string        `mapstructure:"access_key_id"`
-	SecretKey           string        `mapstructure:"secret_key"`
-	SessionToken        string        `mapstructure:"session_token"`
-	SignedExpiry        time.Duration `mapstructure:"signed_expiry"`
-	StorageClass        string        `mapstructure:"storage_class"`
-	PartSize            int64         `mapstructure:"part_size"`
-	Concurrency         int           `mapstructure:"concurrency"`
-	common.PackerConfig `mapstructure:",squash"`
+	Region               string           `mapstructure:"region"`
+	Bucket               string           `mapstructure:"bucket"`
+	CloudFront           string           `mapstructure:"cloudfront"`
+	ManifestPath         string           `mapstructure:"manifest"`
+	BoxName              string           `mapstructure:"box_name"`
+	BoxDir               string           `mapstructure:"box_dir"`
+	Version              string           `mapstructure:"version"`
+	ACL                  string           `mapstructure:"acl"`
+	CredentialFile       string           `mapstructure:"credentials"`
+	CredentialProfile    string           `mapstructure:"profile"`
+	AccessKey            string           `mapstructure:"access_key_id"`
+	SecretKey            string           `mapstructure:"secret_key"`
+	SessionToken         string           `mapstructure:"session_token"`
+	SignedExpiry         time.Duration    `mapstructure:"signed_expiry"`
+	StorageClass         string           `mapstructure:"storage_class"`
+	PartSize             int64            `mapstructure:"part_size"`
+	Concurrency          int              `mapstructure:"concurrency"`
+	Prerelease           string           `mapstructure:"prerelease"`
+	VersionStatus        string           `mapstructure:"version_status"`
+	VersionDescription   string           `mapstructure:"version_description"`
+	KeepVersions         int              `mapstructure:"keep_versions"`
+	KeepProviders        int              `mapstructure:"keep_providers_per_version"`
+	ManifestVersioning   bool             `mapstructure:"manifest_versioning"`
+	Backend              string           `mapstructure:"backend"`
+	Endpoint             string           `mapstructure:"endpoint"`
+	S3ForcePathStyle     bool             `mapstructure:"s3_force_path_style"`
+	DisableSSL           bool             `mapstructure:"disable_ssl"`
+	GCSCredentialsFile   string           `mapstructure:"gcs_credentials_file"`
+	AzureAccount         string           `mapstructure:"azure_account"`
+	AzureAccountKey      string           `mapstructure:"azure_account_key"`
+	AzureContainer       string           `mapstructure:"azure_container"`
+	FilesystemRoot       string           `mapstructure:"filesystem_root"`
+	SigningKey           string           `mapstructure:"signing_key"`
+	SigningKeyPassphrase string           `mapstructure:"signing_key_passphrase"`
+	SigningGpgHome       string           `mapstructure:"signing_gpg_home"`
+	ChecksumType         string           `mapstructure:"checksum_type"`
+	S3ObjectChecksum     bool             `mapstructure:"s3_object_checksum"`
+	AssumeRole           AssumeRoleConfig `mapstructure:"assume_role"`
+	common.PackerConfig  `mapstructure:",squash"`
 
 	ctx interpolate.Context
 }
 
+// AssumeRoleConfig mirrors the assume_role block of Terraform's AWS
+// provider, letting this post-processor publish into a different AWS
+// account than the one its base credentials belong to.
+type AssumeRoleConfig struct {
+	RoleARN     string        `mapstructure:"role_arn"`
+	SessionName string        `mapstructure:"session_name"`
+	ExternalID  string        `mapstructure:"external_id"`
+	Duration    time.Duration `mapstructure:"duration"`
+	Policy      string        `mapstructure:"policy"`
+	MFASerial   string        `mapstructure:"mfa_serial"`
+}
+
 type PostProcessor struct {
 	config  Config
 	session *session.Session
 	s3      *s3.S3
+	store   BoxStore
 }
 
 func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
@@ -95,75 +132,198 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		}
 	}
 
-	var cred *credentials.Credentials = nil // nil credentials use the default aws sdk credential chain
+	// S3 (and S3-compatible) backends need an *s3.S3 client both for the
+	// BoxStore and for the S3-only manifest versioning/rollback features,
+	// so for those backends it's built here, before newBoxStore runs.
+	if p.config.Backend == "" || p.config.Backend == "s3" {
+		awsConfig := &aws.Config{
+			Region:      aws.String(p.config.Region),
+			Credentials: p.credentials(),
+		}
+
+		if p.config.Endpoint != "" {
+			awsConfig.Endpoint = aws.String(p.config.Endpoint)
+			awsConfig.S3ForcePathStyle = aws.Bool(p.config.S3ForcePathStyle)
+			awsConfig.DisableSSL = aws.Bool(p.config.DisableSSL)
+		}
+
+		p.session = session.New(awsConfig)
+		p.s3 = s3.New(p.session)
+
+		// check that we have permission to access the bucket
+		_, err = p.s3.HeadBucket(&s3.HeadBucketInput{
+			Bucket: aws.String(p.config.Bucket),
+		})
+
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("Unable to access the bucket %s:\n%s\nMake sure your credentials are valid and have sufficient permissions", p.config.Bucket, err))
+		}
+
+		if p.config.ManifestVersioning {
+			if err := p.checkBucketVersioning(); err != nil {
+				errs = packer.MultiErrorAppend(errs, err)
+			}
+		}
+	} else if p.config.ManifestVersioning {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("vagrant-s3 manifest_versioning is only supported with the s3 backend"))
+	}
+
+	if p.config.ACL == "" {
+		p.config.ACL = "public-read"
+	}
+
+	if p.config.VersionStatus == "" {
+		p.config.VersionStatus = StatusActive
+	} else if p.config.VersionStatus != StatusActive && p.config.VersionStatus != StatusRevoked {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("vagrant-s3 version_status must be %q or %q", StatusActive, StatusRevoked))
+	}
+
+	// set default storage class
+	if p.config.StorageClass == "" {
+		p.config.StorageClass = "STANDARD"
+	}
+
+	if p.config.PartSize == 0 {
+		p.config.PartSize = s3manager.DefaultUploadPartSize
+	}
+
+	if p.config.Concurrency == 0 {
+		p.config.Concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	if p.config.SigningKey != "" {
+		if keyring, err := p.signingKeyRing(); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		} else if entityWithPrivateKey(keyring) == nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("vagrant-s3 signing_key %q doesn't contain a private key", p.config.SigningKey))
+		}
+	}
+
+	if p.config.ChecksumType == "" {
+		p.config.ChecksumType = "sha256"
+	} else if _, ok := validChecksumTypes[p.config.ChecksumType]; !ok {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("vagrant-s3 checksum_type must be one of: sha256, sha512, sha1, md5"))
+	}
+
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+
+	store, err := newBoxStore(p)
+	if err != nil {
+		return err
+	}
+	p.store = store
+
+	return nil
+}
+
+// credentials builds the chain of credential providers used for the S3
+// backend, tried in order until one succeeds:
+//
+//  1. access_key_id/secret_key, if both are set (StaticProvider)
+//  2. credentials/profile, if either is set (SharedCredentialsProvider)
+//  3. AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, if both are set (STS
+//     AssumeRoleWithWebIdentity, e.g. EKS IRSA)
+//  4. the EC2/ECS instance role
+//  5. the standard AWS environment variables
+//
+// If assume_role.role_arn is also set, that base chain is resolved first and
+// then used as the credentials behind an STS AssumeRole call, layered on top
+// as the sole, final credential - not raced against the base providers in
+// the same chain, where whichever of them resolves first would win and the
+// role would never actually get assumed.
+//
+// This mirrors the AWS SDK's own default credential chain, but also makes
+// room for assume_role, so publishing from CI runners on EKS/ECS and
+// cross-account workflows work without hand-rolled credential plumbing.
+func (p *PostProcessor) credentials() *credentials.Credentials {
+	baseSession := session.New(&aws.Config{Region: aws.String(p.config.Region)})
+
+	var providers []credentials.Provider
 
 	if p.config.AccessKey != "" && p.config.SecretKey != "" {
-		// StaticProvider if both access id and secret are defined
 		// Environmental variables used:
 		// $AWS_SESSION_TOKEN
-		cred = credentials.NewCredentials(&credentials.StaticProvider{
+		providers = append(providers, &credentials.StaticProvider{
 			Value: credentials.Value{
 				AccessKeyID:     p.config.AccessKey,
 				SecretAccessKey: p.config.SecretKey,
 				SessionToken:    p.config.SessionToken,
 			},
 		})
-	} else if p.config.CredentialFile != "" || p.config.CredentialProfile != "" {
-		// SharedCredentialProvider if either credentials file or a profile is defined
+	}
+
+	if p.config.CredentialFile != "" || p.config.CredentialProfile != "" {
 		// Environmental variables used:
 		// $AWS_SHARED_CREDENTIALS_FILE ("$HOME/.aws/credentials" if unset)
 		// $AWS_PROFILE ("default" if unset)
-		cred = credentials.NewCredentials(&credentials.SharedCredentialsProvider{
+		providers = append(providers, &credentials.SharedCredentialsProvider{
 			Filename: p.config.CredentialFile,
 			Profile:  p.config.CredentialProfile,
 		})
-	} else {
-		// EnvProvider as fallback if none of the above matched
-		// Environmental variables used:
-		// $AWS_ACCESS_KEY_ID ($AWS_ACCESS_KEY if unset)
-		// $AWS_SECRET_ACCESS_KEY ($AWS_SECRET_KEY if unset)
-		// $AWS_SESSION_TOKEN
-		cred = credentials.NewCredentials(&credentials.EnvProvider{})
 	}
 
-	p.session = session.New(&aws.Config{
-		Region:      aws.String(p.config.Region),
-		Credentials: cred,
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		if roleARN := os.Getenv("AWS_ROLE_ARN"); roleARN != "" {
+			sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+			providers = append(providers, stscreds.NewWebIdentityRoleProviderWithOptions(
+				sts.New(baseSession), roleARN, sessionName, stscreds.FetchTokenPath(tokenFile)))
+		}
+	}
+
+	providers = append(providers, &ec2rolecreds.EC2RoleProvider{
+		Client: ec2metadata.New(baseSession),
 	})
 
-	p.s3 = s3.New(p.session)
+	// EnvProvider as final fallback if none of the above matched
+	// Environmental variables used:
+	// $AWS_ACCESS_KEY_ID ($AWS_ACCESS_KEY if unset)
+	// $AWS_SECRET_ACCESS_KEY ($AWS_SECRET_KEY if unset)
+	// $AWS_SESSION_TOKEN
+	providers = append(providers, &credentials.EnvProvider{})
 
-	// check that we have permission to access the bucket
-	_, err = p.s3.HeadBucket(&s3.HeadBucketInput{
-		Bucket: aws.String(p.config.Bucket),
-	})
+	baseCreds := credentials.NewChainCredentials(providers)
 
-	if err != nil {
-		errs = packer.MultiErrorAppend(errs, fmt.Errorf("Unable to access the bucket %s:\n%s\nMake sure your credentials are valid and have sufficient permissions", p.config.Bucket, err))
+	if p.config.AssumeRole.RoleARN == "" {
+		return baseCreds
 	}
 
-	if p.config.ACL == "" {
-		p.config.ACL = "public-read"
-	}
+	assumeSession := baseSession.Copy(&aws.Config{Credentials: baseCreds})
+	return credentials.NewCredentials(p.assumeRoleProvider(assumeSession))
+}
 
-	// set default storage class
-	if p.config.StorageClass == "" {
-		p.config.StorageClass = "STANDARD"
-	}
+// assumeRoleProvider builds the STS AssumeRole provider for the assume_role
+// config block, mirroring Terraform's AWS provider assume_role options.
+// baseSession must carry the credentials the role is assumed with.
+func (p *PostProcessor) assumeRoleProvider(baseSession *session.Session) credentials.Provider {
+	cfg := p.config.AssumeRole
 
-	if p.config.PartSize == 0 {
-		p.config.PartSize = s3manager.DefaultUploadPartSize
+	sessionName := cfg.SessionName
+	if sessionName == "" {
+		sessionName = "packer-post-processor-vagrant-s3"
 	}
 
-	if p.config.Concurrency == 0 {
-		p.config.Concurrency = s3manager.DefaultUploadConcurrency
+	provider := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(baseSession),
+		RoleARN:         cfg.RoleARN,
+		RoleSessionName: sessionName,
 	}
 
-	if len(errs.Errors) > 0 {
-		return errs
+	if cfg.ExternalID != "" {
+		provider.ExternalID = aws.String(cfg.ExternalID)
+	}
+	if cfg.Duration != 0 {
+		provider.Duration = cfg.Duration
+	}
+	if cfg.Policy != "" {
+		provider.Policy = aws.String(cfg.Policy)
+	}
+	if cfg.MFASerial != "" {
+		provider.SerialNumber = aws.String(cfg.MFASerial)
 	}
 
-	return nil
+	return provider
 }
 
 func (p *PostProcessor) PostProcess(context context.Context, ui packer.Ui, artifact packer.Artifact) (packer.Artifact, bool, bool, error) {
@@ -207,18 +367,12 @@ func (p *PostProcessor) PostProcess(context context.Context, ui packer.Ui, artif
 	// generate the path to store the box in S3
 	boxPath := fmt.Sprintf("%s/%s/%s", p.config.BoxDir, version, path.Base(box))
 
-	ui.Message("Generating checksum")
-	checksum, err := sum256(box)
-	if err != nil {
-		return nil, false, false, err
-	}
-	ui.Message(fmt.Sprintf("Checksum is %s", checksum))
-
-	// upload the box to S3
+	// upload the box to S3, computing the checksum as bytes stream up
+	// rather than reading the box twice
 	ui.Message(fmt.Sprintf("Uploading box to S3: %s, PartSize: %d, Concurrency: %d", boxPath, p.config.PartSize, p.config.Concurrency))
 
 	start := time.Now()
-	err = p.uploadBox(box, boxPath)
+	checksum, err := p.uploadBox(ui, box, boxPath)
 
 	if err != nil {
 		return nil, false, false, err
@@ -226,6 +380,17 @@ func (p *PostProcessor) PostProcess(context context.Context, ui packer.Ui, artif
 		elapsed := time.Since(start)
 		ui.Message(fmt.Sprintf("Box upload took: %s", elapsed))
 	}
+	ui.Message(fmt.Sprintf("Checksum (%s) is %s", p.config.ChecksumType, checksum))
+
+	var signatureUrl string
+	if p.signingEnabled() {
+		ui.Message("Signing box")
+		sigUrl, err := p.signAndUpload(box, boxPath)
+		if err != nil {
+			return nil, false, false, err
+		}
+		signatureUrl = sigUrl
+	}
 
 	// get the latest manifest so we can add to it
 	ui.Message("Fetching latest manifest")
@@ -237,16 +402,9 @@ func (p *PostProcessor) PostProcess(context context.Context, ui packer.Ui, artif
 	ui.Message(fmt.Sprintf("Adding %s %s box to manifest", provider, version))
 	var url string
 	if p.config.SignedExpiry == 0 {
-		url = generateS3Url(p.config.Region, p.config.Bucket, p.config.CloudFront, boxPath)
+		url = p.store.PublicURL(boxPath)
 	} else {
-		// fetch the new object
-		boxObject, _ := p.s3.GetObjectRequest(&s3.GetObjectInput{
-			Bucket: aws.String(p.config.Bucket),
-			Key:    aws.String(boxPath),
-		})
-
-		url, err = boxObject.Presign(p.config.SignedExpiry)
-
+		url, err = p.store.PresignGet(boxPath, p.config.SignedExpiry)
 		if err != nil {
 			return nil, false, false, err
 		}
@@ -254,18 +412,53 @@ func (p *PostProcessor) PostProcess(context context.Context, ui packer.Ui, artif
 	if err := manifest.add(version, &Provider{
 		Name:         provider,
 		Url:          url,
-		ChecksumType: "sha256",
+		Key:          boxPath,
+		ChecksumType: p.config.ChecksumType,
 		Checksum:     checksum,
+		SignatureUrl: signatureUrl,
 	}); err != nil {
 		return nil, false, false, err
 	}
 
+	for _, v := range manifest.Versions {
+		if v.Version == version {
+			v.Status = p.config.VersionStatus
+			v.DescriptionMarkdown = p.config.VersionDescription
+			break
+		}
+	}
+
+	if p.config.KeepVersions > 0 || p.config.KeepProviders > 0 {
+		removedVersions, removedProviders := manifest.prune(p.config.KeepVersions, p.config.KeepProviders)
+		if err := p.deletePrunedBoxes(ui, removedVersions, removedProviders); err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	if p.signingEnabled() {
+		manifest.Signature = p.store.PublicURL(manifestSignatureKey(p.config.ManifestPath))
+	}
+
 	ui.Message(fmt.Sprintf("Uploading the manifest: %s", p.config.ManifestPath))
-	if err := p.putManifest(manifest); err != nil {
+	versionId, err := p.putManifest(manifest)
+	if err != nil {
 		return nil, false, false, err
 	}
 
-	return &Artifact{generateS3Url(p.config.Region, p.config.Bucket, p.config.CloudFront, p.config.ManifestPath)}, true, false, nil
+	if p.signingEnabled() {
+		ui.Message("Signing manifest")
+		if err := p.signManifest(manifest); err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	if p.config.ManifestVersioning {
+		if err := p.recordManifestVersion(versionId, version, provider); err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	return &Artifact{p.store.PublicURL(p.config.ManifestPath)}, true, false, nil
 }
 
 func (p *PostProcessor) determineVersion() (string, error) {
@@ -273,108 +466,171 @@ func (p *PostProcessor) determineVersion() (string, error) {
 	if manifest, err := p.getManifest(); err != nil {
 		return "", err
 	} else {
-		return manifest.getNextVersion(), nil
+		return manifest.getNextVersion(p.config.Prerelease), nil
+	}
+}
+
+// deletePrunedBoxes removes the S3 objects for versions and providers that
+// prune dropped from the manifest, so old boxes don't outlive their
+// metadata and keep counting against the bucket's storage.
+func (p *PostProcessor) deletePrunedBoxes(ui packer.Ui, removedVersions []*Version, removedProviders []*Provider) error {
+	for _, v := range removedVersions {
+		for _, provider := range v.Providers {
+			if err := p.deleteBox(ui, v.Version, provider); err != nil {
+				return err
+			}
+		}
+	}
+	for _, provider := range removedProviders {
+		// removedProviders doesn't carry its owning version, but deleteBox
+		// only uses the provider anyway.
+		if err := p.deleteBox(ui, "", provider); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (p *PostProcessor) uploadBox(box, boxPath string) error {
-	// open the file for reading
+func (p *PostProcessor) deleteBox(ui packer.Ui, version string, provider *Provider) error {
+	if version != "" {
+		ui.Message(fmt.Sprintf("Pruning %s %s box (retention window exceeded)", provider.Name, version))
+	}
+
+	key := provider.Key
+	if key == "" {
+		// Providers added before Key was recorded don't have one; fall back
+		// to recovering it from the URL, which only works for a public (not
+		// presigned) URL.
+		var err error
+		key, err = p.keyFromUrl(provider.Url)
+		if err != nil {
+			ui.Message(fmt.Sprintf("Skipping prune of %s: %s", provider.Url, err))
+			return nil
+		}
+	}
+
+	if err := p.store.Delete(key); err != nil {
+		return err
+	}
+
+	if p.signingEnabled() {
+		// Best-effort: signAndUpload writes the detached signature alongside
+		// the box at key+signatureSuffix, and it would otherwise be orphaned
+		// forever once the box itself is pruned.
+		if err := p.store.Delete(key + signatureSuffix); err != nil {
+			ui.Message(fmt.Sprintf("Warning: unable to prune signature for %s: %s", key, err))
+		}
+	}
+
+	return nil
+}
+
+// keyFromUrl recovers the object key from a URL previously generated by
+// store.PublicURL, for pruning manifests written before Provider.Key existed.
+// It errors out on URLs it doesn't recognise (e.g. a presigned URL, where
+// the path has query string noise) rather than guessing and deleting the
+// wrong object.
+func (p *PostProcessor) keyFromUrl(url string) (string, error) {
+	prefix := p.store.PublicURL("")
+	if strings.HasPrefix(url, prefix) && !strings.Contains(url, "?") {
+		return strings.TrimPrefix(url, prefix), nil
+	}
+	return "", fmt.Errorf("unable to determine object key from url %q", url)
+}
+
+// uploadBox streams the box to the store, computing its checksum as bytes
+// pass through rather than reading the box a second time afterwards.
+func (p *PostProcessor) uploadBox(ui packer.Ui, box, boxPath string) (string, error) {
 	file, err := os.Open(box)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer file.Close()
 
-	// upload the file
-	uploader := s3manager.NewUploader(p.session, func(u *s3manager.Uploader) {
-		u.PartSize = p.config.PartSize
-		u.Concurrency = p.config.Concurrency
-	})
+	boxStat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
 
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Body:         file,
-		Bucket:       aws.String(p.config.Bucket),
-		Key:          aws.String(boxPath),
-		ACL:          aws.String(p.config.ACL),
-		StorageClass: aws.String(p.config.StorageClass),
-	})
+	h, err := newChecksumHash(p.config.ChecksumType)
+	if err != nil {
+		return "", err
+	}
+	tee := io.TeeReader(file, h)
 
-	return err
+	useNativeChecksum := p.config.ChecksumType == "md5" || p.config.S3ObjectChecksum
+	if cu, ok := p.store.(ChecksumUploader); ok && useNativeChecksum {
+		err = cu.UploadWithChecksum(ui, boxPath, tee, boxStat.Size(), p.config.ChecksumType)
+	} else {
+		err = p.store.Upload(boxPath, tee, boxStat.Size())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func (p *PostProcessor) getManifest() (*Manifest, error) {
-	result, err := p.s3.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(p.config.Bucket),
-		Key:    aws.String(p.config.ManifestPath),
-	})
-
+	body, err := p.store.Get(p.config.ManifestPath)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "NoSuchKey" {
-				return &Manifest{Name: p.config.BoxName}, nil
-			}
+		if err == ErrNotExist {
+			return &Manifest{Name: p.config.BoxName}, nil
 		}
 		return nil, err
 	}
+	defer body.Close()
 
-	defer result.Body.Close()
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.signingEnabled() {
+		if err := p.verifyRemoteManifest(raw); err != nil {
+			return nil, err
+		}
+	}
 
 	manifest := &Manifest{}
-	if err := json.NewDecoder(result.Body).Decode(manifest); err != nil {
+	if err := json.Unmarshal(raw, manifest); err != nil {
 		return nil, err
 	}
 	return manifest, nil
 }
 
-func (p *PostProcessor) putManifest(manifest *Manifest) error {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(manifest); err != nil {
+// verifyRemoteManifest fetches the manifest's detached signature and checks
+// it against raw before the manifest is trusted, aborting the publish if a
+// tampered remote manifest is detected. A manifest with no signature yet
+// (e.g. the very first publish) is allowed through.
+func (p *PostProcessor) verifyRemoteManifest(raw []byte) error {
+	sig, err := p.store.Get(manifestSignatureKey(p.config.ManifestPath))
+	if err != nil {
+		if err == ErrNotExist {
+			return nil
+		}
 		return err
 	}
+	defer sig.Close()
 
-	_, err := p.s3.PutObject(&s3.PutObjectInput{
-		Body:        strings.NewReader(buf.String()),
-		Bucket:      aws.String(p.config.Bucket),
-		Key:         aws.String(p.config.ManifestPath),
-		ContentType: aws.String("application/json"),
-		ACL:         aws.String(p.config.ACL),
-	})
-
+	sigBytes, err := ioutil.ReadAll(sig)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return p.verifyManifest(raw, sigBytes)
 }
 
-func generateS3Url(region, bucket, cloudFront, key string) string {
-	if cloudFront != "" {
-		return fmt.Sprintf("https://%s/%s", cloudFront, key)
-	}
-
-	if region == "us-east-1" {
-		return fmt.Sprintf("https://s3.amazonaws.com/%s/%s", bucket, key)
-	}
-
-	return fmt.Sprintf("https://s3-%s.amazonaws.com/%s/%s", region, bucket, key)
-}
-
-// calculates a sha256 checksum of the file
-func sum256(filePath string) (string, error) {
-	// open the file for reading
-	file, err := os.Open(filePath)
-
-	if err != nil {
+// putManifest uploads the manifest and returns a backend-specific version
+// identifier for the object that was written (e.g. an S3 VersionId), if the
+// backend supports one.
+func (p *PostProcessor) putManifest(manifest *Manifest) (string, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(manifest); err != nil {
 		return "", err
 	}
 
-	defer file.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, file); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return p.store.Put(p.config.ManifestPath, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "application/json")
 }
 
 // converts a packer builder name to the corresponding vagrant provider
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// runRollback implements the "rollback" subcommand: list or restore prior
+// versions of a manifest object, for recovering from a botched publish
+// (bad checksum, wrong URL) without hand-editing JSON. It talks to S3
+// directly rather than through packer's plugin RPC, since it's meant to be
+// run by a human, not by packer core.
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	region := fs.String("region", "", "AWS region of the bucket")
+	bucket := fs.String("bucket", "", "S3 bucket containing the manifest")
+	manifestPath := fs.String("manifest", "", "key of the manifest object")
+	versionId := fs.String("version-id", "", "VersionId to restore; if omitted, prior versions are listed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *region == "" || *bucket == "" || *manifestPath == "" {
+		return fmt.Errorf("rollback requires -region, -bucket and -manifest")
+	}
+
+	p := &PostProcessor{
+		config: Config{
+			Region:       *region,
+			Bucket:       *bucket,
+			ManifestPath: *manifestPath,
+			ACL:          "public-read",
+		},
+	}
+	p.session = session.New(&aws.Config{Region: aws.String(*region)})
+	p.s3 = s3.New(p.session)
+
+	if *versionId == "" {
+		versions, err := p.listManifestVersions()
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			fmt.Printf("%s\t%s\tlatest=%v\tsize=%d\n",
+				aws.StringValue(v.VersionId),
+				aws.TimeValue(v.LastModified).Format("2006-01-02T15:04:05Z07:00"),
+				aws.BoolValue(v.IsLatest),
+				aws.Int64Value(v.Size))
+		}
+		return nil
+	}
+
+	if err := p.restoreManifestVersion(*versionId); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s/%s to version %s\n", *bucket, *manifestPath, *versionId)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+// ErrNotExist is returned by Get when the requested key doesn't exist in the
+// store, so callers can distinguish "not found" (e.g. no manifest published
+// yet) from a real backend error. Head reports a missing key as false with a
+// nil error instead.
+var ErrNotExist = errors.New("object does not exist")
+
+// BoxStore abstracts the object storage backend used to publish boxes and
+// the manifest, so PostProcessor isn't hard-wired to AWS S3. Select an
+// implementation via the "backend" config field.
+type BoxStore interface {
+	// Head reports whether key exists in the store.
+	Head(key string) (bool, error)
+	// Get opens key for reading. The caller must close the returned reader.
+	Get(key string) (io.ReadCloser, error)
+	// Put uploads a small object (e.g. the manifest) in one shot, returning
+	// a backend-specific version identifier if the backend supports one.
+	Put(key string, body io.Reader, size int64, contentType string) (string, error)
+	// Upload uploads a (potentially large) object, using multipart/chunked
+	// upload where the backend supports it.
+	Upload(key string, body io.Reader, size int64) error
+	// Delete removes key from the store.
+	Delete(key string) error
+	// PresignGet returns a time-limited URL for downloading key. Backends
+	// that can't presign return an error.
+	PresignGet(key string, ttl time.Duration) (string, error)
+	// PublicURL returns the URL at which key is expected to be publicly
+	// reachable, without making any request to the backend.
+	PublicURL(key string) string
+}
+
+// ChecksumUploader is implemented by BoxStore backends that can verify an
+// upload's integrity server-side using a native checksum, rather than
+// relying solely on the checksum recorded in the manifest. Currently only
+// S3Store implements it; backends that don't are skipped in favour of a
+// plain Upload. ui is used to warn when the native check can't be performed
+// for the given upload, rather than silently reporting success.
+type ChecksumUploader interface {
+	UploadWithChecksum(ui packer.Ui, key string, body io.Reader, size int64, checksumType string) error
+}
+
+// newBoxStore constructs the BoxStore selected by config.Backend. "s3" (the
+// default) also covers S3-compatible endpoints such as MinIO, Wasabi and
+// Ceph via the endpoint/s3_force_path_style/disable_ssl config fields.
+func newBoxStore(p *PostProcessor) (BoxStore, error) {
+	switch p.config.Backend {
+	case "", "s3":
+		return newS3Store(p)
+	case "gcs":
+		return newGCSStore(p)
+	case "azure":
+		return newAzureStore(p)
+	case "filesystem":
+		return newFilesystemStore(p)
+	default:
+		return nil, fmt.Errorf("unknown backend %q, must be one of: s3, gcs, azure, filesystem", p.config.Backend)
+	}
+}
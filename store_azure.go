@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// AzureStore is the BoxStore implementation for Azure Blob Storage.
+// azure_account/azure_account_key authenticate against the account;
+// azure_container selects the container boxes and the manifest are
+// published into.
+type AzureStore struct {
+	container azblob.ContainerURL
+	account   string
+}
+
+func newAzureStore(p *PostProcessor) (BoxStore, error) {
+	if p.config.AzureAccount == "" || p.config.AzureAccountKey == "" || p.config.AzureContainer == "" {
+		return nil, fmt.Errorf("vagrant-s3 azure_account, azure_account_key and azure_container must be set when backend is \"azure\"")
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(p.config.AzureAccount, p.config.AzureAccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure credentials: %s", err)
+	}
+
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	containerURL, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", p.config.AzureAccount, p.config.AzureContainer))
+	if err != nil {
+		return nil, err
+	}
+
+	return &AzureStore{
+		container: azblob.NewContainerURL(*containerURL, pipeline),
+		account:   p.config.AzureAccount,
+	}, nil
+}
+
+func (s *AzureStore) blob(key string) azblob.BlockBlobURL {
+	return s.container.NewBlockBlobURL(key)
+}
+
+func (s *AzureStore) Head(key string) (bool, error) {
+	_, err := s.blob(key).GetProperties(context.Background(), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if isAzureNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *AzureStore) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.blob(key).Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if isAzureNotFound(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{}), nil
+}
+
+func (s *AzureStore) Put(key string, body io.Reader, size int64, contentType string) (string, error) {
+	resp, err := azblob.UploadStreamToBlockBlob(context.Background(), body, s.blob(key), azblob.UploadStreamToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: contentType},
+	})
+	if err != nil {
+		return "", err
+	}
+	// resp.Version() is the x-ms-version API version header, not the blob's
+	// version; the blob version ID is only present when blob versioning is
+	// enabled on the account.
+	if v, ok := resp.(interface{ VersionID() string }); ok {
+		return v.VersionID(), nil
+	}
+	return "", nil
+}
+
+func (s *AzureStore) Upload(key string, body io.Reader, size int64) error {
+	_, err := azblob.UploadStreamToBlockBlob(context.Background(), body, s.blob(key), azblob.UploadStreamToBlockBlobOptions{})
+	return err
+}
+
+func (s *AzureStore) Delete(key string) error {
+	_, err := s.blob(key).Delete(context.Background(), azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return err
+}
+
+func (s *AzureStore) PresignGet(key string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("the azure backend doesn't support presigned URLs yet")
+}
+
+func (s *AzureStore) PublicURL(key string) string {
+	return s.blob(key).URL().String()
+}
+
+func isAzureNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	storageErr, ok := err.(azblob.StorageError)
+	return ok && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FilesystemStore is a BoxStore backed by a local directory tree. It's
+// useful for tests and for air-gapped mirrors where boxes are published to
+// a local path and synced out-of-band (e.g. rsync to an internal mirror).
+type FilesystemStore struct {
+	root string
+	acl  string
+}
+
+func newFilesystemStore(p *PostProcessor) (BoxStore, error) {
+	if p.config.FilesystemRoot == "" {
+		return nil, fmt.Errorf("vagrant-s3 filesystem_root must be set when backend is \"filesystem\"")
+	}
+
+	if err := os.MkdirAll(p.config.FilesystemRoot, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create filesystem_root %q: %s", p.config.FilesystemRoot, err)
+	}
+
+	return &FilesystemStore{root: p.config.FilesystemRoot}, nil
+}
+
+func (s *FilesystemStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *FilesystemStore) Head(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *FilesystemStore) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (s *FilesystemStore) Put(key string, body io.Reader, size int64, contentType string) (string, error) {
+	return "", s.Upload(key, body, size)
+}
+
+func (s *FilesystemStore) Upload(key string, body io.Reader, size int64) error {
+	dest := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, body)
+	return err
+}
+
+func (s *FilesystemStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FilesystemStore) PresignGet(key string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("the filesystem backend doesn't support presigned URLs")
+}
+
+func (s *FilesystemStore) PublicURL(key string) string {
+	return "file://" + s.path(key)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCSStore is the BoxStore implementation for Google Cloud Storage.
+// config.Bucket is the GCS bucket name; gcs_credentials_file points at a
+// service account JSON key, falling back to Application Default
+// Credentials (e.g. GOOGLE_APPLICATION_CREDENTIALS) when unset.
+type GCSStore struct {
+	client     *storage.Client
+	bucketName string
+	bucket     *storage.BucketHandle
+}
+
+func newGCSStore(p *PostProcessor) (BoxStore, error) {
+	ctx := context.Background()
+
+	var opts []option.ClientOption
+	if p.config.GCSCredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(p.config.GCSCredentialsFile))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCS client: %s", err)
+	}
+
+	return &GCSStore{
+		client:     client,
+		bucketName: p.config.Bucket,
+		bucket:     client.Bucket(p.config.Bucket),
+	}, nil
+}
+
+func (s *GCSStore) object(key string) *storage.ObjectHandle {
+	return s.bucket.Object(key)
+}
+
+func (s *GCSStore) Head(key string) (bool, error) {
+	_, err := s.object(key).Attrs(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *GCSStore) Get(key string) (io.ReadCloser, error) {
+	r, err := s.object(key).NewReader(context.Background())
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotExist
+	}
+	return r, err
+}
+
+func (s *GCSStore) Put(key string, body io.Reader, size int64, contentType string) (string, error) {
+	w := s.object(key).NewWriter(context.Background())
+	w.ContentType = contentType
+
+	if _, err := io.Copy(w, body); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	// Object generation doubles as a version identifier analogous to an S3
+	// VersionId, if object versioning is enabled on the bucket.
+	return fmt.Sprintf("%d", w.Attrs().Generation), nil
+}
+
+func (s *GCSStore) Upload(key string, body io.Reader, size int64) error {
+	_, err := s.Put(key, body, size, "application/octet-stream")
+	return err
+}
+
+func (s *GCSStore) Delete(key string) error {
+	return s.object(key).Delete(context.Background())
+}
+
+func (s *GCSStore) PresignGet(key string, ttl time.Duration) (string, error) {
+	return "", fmt.Errorf("the gcs backend doesn't support presigned URLs yet")
+}
+
+func (s *GCSStore) PublicURL(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucketName, key)
+}
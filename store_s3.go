@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hashicorp/packer/packer"
+)
+
+// S3Store is the BoxStore implementation for AWS S3 and S3-compatible
+// endpoints (MinIO, Wasabi, Ceph, ...). Point it at a non-AWS endpoint with
+// the "endpoint" config field; "s3_force_path_style" is usually required
+// for those, since they rarely support virtual-host-style buckets.
+type S3Store struct {
+	s3      *s3.S3
+	session *session.Session
+	config  *Config
+}
+
+// newS3Store wraps the S3 client and session that Configure already built
+// (using the endpoint/credentials/region config), so there's only one place
+// that assembles an *s3.S3 from config.
+func newS3Store(p *PostProcessor) (BoxStore, error) {
+	return &S3Store{
+		s3:      p.s3,
+		session: p.session,
+		config:  &p.config,
+	}, nil
+}
+
+func (s *S3Store) Head(key string) (bool, error) {
+	_, err := s.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	result, err := s.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchKey" {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+func (s *S3Store) Put(key string, body io.Reader, size int64, contentType string) (string, error) {
+	result, err := s.s3.PutObject(&s3.PutObjectInput{
+		Body:         toReadSeeker(body),
+		Bucket:       aws.String(s.config.Bucket),
+		Key:          aws.String(key),
+		ContentType:  aws.String(contentType),
+		ACL:          aws.String(s.config.ACL),
+		StorageClass: aws.String(s.config.StorageClass),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(result.VersionId), nil
+}
+
+func (s *S3Store) Upload(key string, body io.Reader, size int64) error {
+	uploader := s3manager.NewUploader(s.session, func(u *s3manager.Uploader) {
+		u.PartSize = s.config.PartSize
+		u.Concurrency = s.config.Concurrency
+	})
+
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Body:         body,
+		Bucket:       aws.String(s.config.Bucket),
+		Key:          aws.String(key),
+		ACL:          aws.String(s.config.ACL),
+		StorageClass: aws.String(s.config.StorageClass),
+	})
+	return err
+}
+
+// UploadWithChecksum uploads body the same way as Upload, but additionally
+// asks S3 to verify the object's integrity server-side: for "sha256"/"sha1"
+// it's done via S3's native ChecksumAlgorithm, checked by S3 as the upload
+// streams in; for "md5" there's no such native algorithm, so it's checked
+// afterwards against the uploaded object's ETag, which S3 sets to the MD5
+// digest for any object that wasn't uploaded as a multipart upload. Above
+// PartSize, s3manager always does a multipart upload, whose ETag isn't a
+// plain MD5 digest, so the check can't be performed; ui is warned rather
+// than silently treating the upload as verified. S3 has no native sha512
+// algorithm either, so for "sha512" ui is warned the same way.
+func (s *S3Store) UploadWithChecksum(ui packer.Ui, key string, body io.Reader, size int64, checksumType string) error {
+	h, err := newChecksumHash(checksumType)
+	if err != nil {
+		return err
+	}
+	tee := io.TeeReader(body, h)
+
+	uploader := s3manager.NewUploader(s.session, func(u *s3manager.Uploader) {
+		u.PartSize = s.config.PartSize
+		u.Concurrency = s.config.Concurrency
+	})
+
+	input := &s3manager.UploadInput{
+		Body:         tee,
+		Bucket:       aws.String(s.config.Bucket),
+		Key:          aws.String(key),
+		ACL:          aws.String(s.config.ACL),
+		StorageClass: aws.String(s.config.StorageClass),
+	}
+
+	switch checksumType {
+	case "sha256":
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	case "sha1":
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha1)
+	case "sha512":
+		ui.Message(fmt.Sprintf("Warning: unable to verify sha512 checksum of %s server-side (S3 has no native sha512 checksum)", key))
+	}
+
+	result, err := uploader.Upload(input)
+	if err != nil {
+		return fmt.Errorf("error uploading %s (including any server-side checksum mismatch): %s", key, err)
+	}
+
+	if checksumType == "md5" {
+		if size < s.config.PartSize {
+			etag := strings.Trim(aws.StringValue(result.ETag), `"`)
+			digest := fmt.Sprintf("%x", h.Sum(nil))
+			if etag != digest {
+				return fmt.Errorf("checksum mismatch uploading %s: expected md5 %s, S3 ETag was %s", key, digest, etag)
+			}
+		} else {
+			// size >= PartSize means s3manager did a multipart upload, whose
+			// ETag isn't a plain MD5 digest, so there's nothing to compare
+			// against; the manifest checksum is still the locally-computed
+			// digest, but S3 hasn't verified it server-side.
+			ui.Message(fmt.Sprintf("Warning: unable to verify md5 checksum of %s server-side (object was uploaded as multipart); only sha256/sha1 checksum_type can be verified above PartSize", key))
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	_, err := s.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Store) PresignGet(key string, ttl time.Duration) (string, error) {
+	req, _ := s.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(ttl)
+}
+
+func (s *S3Store) PublicURL(key string) string {
+	if s.config.CloudFront != "" {
+		return fmt.Sprintf("https://%s/%s", s.config.CloudFront, key)
+	}
+
+	if s.config.Endpoint != "" {
+		if s.config.S3ForcePathStyle {
+			return fmt.Sprintf("%s/%s/%s", s.config.Endpoint, s.config.Bucket, key)
+		}
+		// virtual-host style: the bucket is a subdomain of the endpoint host
+		endpoint := strings.TrimSuffix(s.config.Endpoint, "/")
+		if i := strings.Index(endpoint, "://"); i >= 0 {
+			return fmt.Sprintf("%s%s.%s/%s", endpoint[:i+3], s.config.Bucket, endpoint[i+3:], key)
+		}
+		return fmt.Sprintf("%s.%s/%s", s.config.Bucket, endpoint, key)
+	}
+
+	if s.config.Region == "us-east-1" {
+		return fmt.Sprintf("https://s3.amazonaws.com/%s/%s", s.config.Bucket, key)
+	}
+
+	return fmt.Sprintf("https://s3-%s.amazonaws.com/%s/%s", s.config.Region, s.config.Bucket, key)
+}
+
+// toReadSeeker adapts an io.Reader for PutObjectInput.Body, which requires
+// an io.ReadSeeker; callers in this package always pass one already (a
+// *bytes.Reader or *strings.Reader), so this is just a type assertion.
+func toReadSeeker(r io.Reader) io.ReadSeeker {
+	return r.(io.ReadSeeker)
+}